@@ -5,27 +5,67 @@ import (
 	"path"
 
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
 	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/klog/v2"
 
 	"github.com/kubeedge/edgemesh/agent/cmd/edgemesh-agent/app/config"
+	cniserver "github.com/kubeedge/edgemesh/agent/pkg/cni/server"
 	meshConstants "github.com/kubeedge/edgemesh/common/constants"
+	"github.com/kubeedge/edgemesh/common/util"
 	"github.com/kubeedge/kubeedge/common/constants"
 	"github.com/kubeedge/kubeedge/pkg/util/validation"
 )
 
+const (
+	// DefaultCNISocketPath is where edgemesh-agent listens for requests
+	// forwarded by the edgemesh-cni shim binary.
+	DefaultCNISocketPath = "/run/edgemesh/cni.sock"
+	// DefaultCNIConfDir is the kubelet CNI configuration directory edgemesh
+	// drops its CNI conf list into so kubelet picks it up as the cluster CNI.
+	DefaultCNIConfDir = "/etc/cni/net.d"
+	// DefaultCNIMTU is the MTU assigned to the veth pairs edgemesh-cni creates.
+	DefaultCNIMTU = 1500
+)
+
 type EdgeMeshAgentOptions struct {
 	ConfigFile string
+
+	// CNISocketPath is the Unix domain socket edgemesh-agent's CNI handler
+	// listens on for requests forwarded by the edgemesh-cni shim binary.
+	CNISocketPath string
+	// CNIConfDir is the kubelet CNI configuration directory edgemesh-agent
+	// writes its CNI conf list into.
+	CNIConfDir string
+	// CNIMTU is the MTU assigned to the veth pairs edgemesh-cni creates.
+	CNIMTU int
+
+	// ListenInterface overrides the network interface edgemesh-agent
+	// listens on for DNS and SOCKS5 traffic. When empty, the config file's
+	// value is used, and if that is empty too it is auto-detected with
+	// util.ChooseHostInterface.
+	ListenInterface string
 }
 
 func NewEdgeMeshAgentOptions() *EdgeMeshAgentOptions {
 	return &EdgeMeshAgentOptions{
-		ConfigFile: path.Join(constants.DefaultConfigDir, meshConstants.EdgeMeshAgentConfigFileName),
+		ConfigFile:    path.Join(constants.DefaultConfigDir, meshConstants.EdgeMeshAgentConfigFileName),
+		CNISocketPath: DefaultCNISocketPath,
+		CNIConfDir:    DefaultCNIConfDir,
+		CNIMTU:        DefaultCNIMTU,
 	}
 }
 
 func (o *EdgeMeshAgentOptions) Flags() (fss cliflag.NamedFlagSets) {
 	fs := fss.FlagSet("global")
 	fs.StringVar(&o.ConfigFile, "config-file", o.ConfigFile, "The path to the configuration file. Flags override values in this file.")
+
+	cniFs := fss.FlagSet("cni")
+	cniFs.StringVar(&o.CNISocketPath, "cni-socket-path", o.CNISocketPath, "The Unix domain socket edgemesh-agent's CNI handler listens on.")
+	cniFs.StringVar(&o.CNIConfDir, "cni-conf-dir", o.CNIConfDir, "The kubelet CNI configuration directory to install edgemesh's CNI conf list into.")
+	cniFs.IntVar(&o.CNIMTU, "cni-mtu", o.CNIMTU, "The MTU assigned to the veth pairs edgemesh-cni creates.")
+
+	fs.StringVar(&o.ListenInterface, "listen-interface", o.ListenInterface, "The network interface to listen on for DNS and SOCKS5 traffic. Overrides the config file; auto-detected if set in neither.")
 	return
 }
 
@@ -44,5 +84,31 @@ func (o *EdgeMeshAgentOptions) Config() (*config.EdgeMeshAgentConfig, error) {
 	if err := cfg.Parse(o.ConfigFile); err != nil {
 		return nil, err
 	}
+
+	if o.ListenInterface != "" {
+		cfg.Modules.EdgeDNS.ListenInterface = o.ListenInterface
+	}
+	if cfg.Modules.EdgeDNS.ListenInterface == "" {
+		iface, err := util.ChooseHostInterface()
+		if err != nil {
+			return nil, fmt.Errorf("failed to auto-detect listen interface: %w", err)
+		}
+		klog.Infof("listen-interface not set, auto-detected %s", iface)
+		cfg.Modules.EdgeDNS.ListenInterface = iface
+	}
+
+	cfg.Modules.EdgeCNI.SocketPath = o.CNISocketPath
+	cfg.Modules.EdgeCNI.ConfDir = o.CNIConfDir
+	cfg.Modules.EdgeCNI.MTU = o.CNIMTU
+
 	return cfg, nil
 }
+
+// StartCNIServer starts the CNI handler edgemesh-cni forwards ADD/DEL/CHECK
+// requests to, and installs edgemesh's CNI conf list so kubelet picks it up
+// as the cluster network plugin. The running edgemesh-agent process calls
+// this once at startup, after Config(), with the kubeClient and nodeName it
+// already has on hand.
+func (o *EdgeMeshAgentOptions) StartCNIServer(kubeClient kubernetes.Interface, nodeName string) error {
+	return cniserver.Run(o.CNISocketPath, o.CNIConfDir, o.CNIMTU, kubeClient, nodeName)
+}
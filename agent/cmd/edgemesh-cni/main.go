@@ -0,0 +1,19 @@
+// Command edgemesh-cni is the CNI binary kubelet invokes for every pod
+// ADD/DEL/CHECK. It is a thin shim: it forwards the invocation to the
+// edgemesh-agent running on the same node, which does the actual veth and
+// routing work. See agent/pkg/cni for the shared request/result types.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kubeedge/edgemesh/agent/pkg/cni/shim"
+)
+
+func main() {
+	if err := shim.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "edgemesh-cni: %v\n", err)
+		os.Exit(1)
+	}
+}
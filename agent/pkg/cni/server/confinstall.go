@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cniPluginName is the binary name kubelet looks up on CNI_PATH for every
+// invocation; it must match the edgemesh-cni command built from
+// agent/cmd/edgemesh-cni.
+const cniPluginName = "edgemesh-cni"
+
+// confListFileName is the file edgemesh installs into confDir; the "10-"
+// prefix makes kubelet (which picks the alphabetically-first conflist in the
+// directory) select it ahead of any other CNI plugin dropped in alongside it.
+const confListFileName = "10-edgemesh.conflist"
+
+// InstallConfList writes a CNI configuration list naming edgemesh-cni as the
+// cluster network plugin into confDir, so kubelet picks it up. It is
+// idempotent: calling it again (e.g. on every agent restart) just
+// overwrites the file with the same content.
+func InstallConfList(confDir string) error {
+	conf := map[string]interface{}{
+		"cniVersion": "1.0.0",
+		"name":       "edgemesh",
+		"plugins": []map[string]interface{}{
+			{"type": cniPluginName},
+		},
+	}
+	data, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cni conf list: %w", err)
+	}
+
+	if err := os.MkdirAll(confDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cni conf dir %s: %w", confDir, err)
+	}
+	path := filepath.Join(confDir, confListFileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// podIPAM is a minimal in-memory allocator for the pod CIDR assigned to this
+// node. It is intentionally simple: edgemesh's CNI is meant to get a single
+// edge node working without depending on an external IPAM plugin, not to
+// replace one. Allocations are tracked per container ID so releasePodIP can
+// return an address to the free list on CNI DEL, and the free list is
+// consulted before handing out a new address. All of this state lives in
+// memory only: it is lost when the agent restarts, after which allocation
+// starts again from the first host address and can hand out addresses
+// still held by running pods.
+//
+// IPv4 only: podCIDR must be an IPv4 CIDR. An IPv6 pod CIDR is rejected with
+// an error rather than attempted, since the allocator packs addresses into a
+// uint32.
+type podIPAM struct {
+	mu        sync.Mutex
+	cidr      string
+	next      uint32
+	free      []uint32
+	allocated map[string]uint32 // containerID -> offset from the CIDR base
+}
+
+var nodeIPAM = &podIPAM{}
+
+// allocatePodIP returns an address for containerID out of podCIDR, in CIDR
+// notation (e.g. "10.244.0.2/24"). The first two addresses (the network
+// address and the one after it) are never handed out, the pod gateway being
+// the link-local podGatewayIP instead, and the last (the broadcast address) is never
+// handed out; once every address between those has been allocated, it
+// returns an error instead of rolling into the next subnet. Calling it again
+// for a containerID that already holds an address returns that same
+// address.
+func allocatePodIP(podCIDR, containerID string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(podCIDR)
+	if err != nil {
+		return "", fmt.Errorf("invalid pod cidr %s: %w", podCIDR, err)
+	}
+	ip4 := ipNet.IP.To4()
+	if ip4 == nil {
+		return "", fmt.Errorf("pod cidr %s: ipv6 pod CIDRs are not supported by this allocator", podCIDR)
+	}
+	ones, bits := ipNet.Mask.Size()
+	// hostMax is the last valid host offset in the CIDR: all-ones is the
+	// broadcast address, so it's excluded too.
+	hostMax := uint32(1)<<uint(bits-ones) - 2
+
+	nodeIPAM.mu.Lock()
+	defer nodeIPAM.mu.Unlock()
+	if nodeIPAM.cidr != podCIDR {
+		nodeIPAM.cidr = podCIDR
+		nodeIPAM.next = 2
+		nodeIPAM.free = nil
+		nodeIPAM.allocated = make(map[string]uint32)
+	}
+
+	if offset, ok := nodeIPAM.allocated[containerID]; ok {
+		return formatPodIP(ip4, offset, ones), nil
+	}
+
+	var offset uint32
+	if n := len(nodeIPAM.free); n > 0 {
+		offset = nodeIPAM.free[n-1]
+		nodeIPAM.free = nodeIPAM.free[:n-1]
+	} else {
+		if nodeIPAM.next > hostMax {
+			return "", fmt.Errorf("pod cidr %s is exhausted", podCIDR)
+		}
+		offset = nodeIPAM.next
+		nodeIPAM.next++
+	}
+	nodeIPAM.allocated[containerID] = offset
+
+	return formatPodIP(ip4, offset, ones), nil
+}
+
+// releasePodIP returns the address held by containerID, if any, to the free
+// list so it can be reused by a later allocatePodIP call for this podCIDR.
+func releasePodIP(containerID string) {
+	nodeIPAM.mu.Lock()
+	defer nodeIPAM.mu.Unlock()
+	offset, ok := nodeIPAM.allocated[containerID]
+	if !ok {
+		return
+	}
+	delete(nodeIPAM.allocated, containerID)
+	nodeIPAM.free = append(nodeIPAM.free, offset)
+}
+
+func formatPodIP(base net.IP, offset uint32, ones int) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(base)+offset)
+	return fmt.Sprintf("%s/%d", ip.String(), ones)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// Run resolves this node's PodCIDR, installs edgemesh's CNI conf list into
+// confDir so kubelet picks edgemesh-cni as the cluster network plugin, and
+// starts the CNI handler on socketPath. It is the integration point the
+// running edgemesh-agent process calls once, at startup, to make the CNI
+// shim/server split added by edgemesh's CNI support actually reachable.
+func Run(socketPath, confDir string, mtu int, kubeClient kubernetes.Interface, nodeName string) error {
+	podCIDR, err := nodePodCIDR(kubeClient, nodeName)
+	if err != nil {
+		return fmt.Errorf("failed to resolve pod cidr for node %s: %w", nodeName, err)
+	}
+
+	if err := InstallConfList(confDir); err != nil {
+		return fmt.Errorf("failed to install cni conf list: %w", err)
+	}
+
+	srv, err := NewServer(socketPath, podCIDR, mtu)
+	if err != nil {
+		return fmt.Errorf("failed to create cni server: %w", err)
+	}
+	srv.Start()
+
+	klog.Infof("cni server listening on %s for pod cidr %s", socketPath, podCIDR)
+	return nil
+}
+
+// nodePodCIDR looks up nodeName's Spec.PodCIDR, the range the kubelet/
+// controller-manager assigned this node to allocate pod IPs from.
+func nodePodCIDR(kubeClient kubernetes.Interface, nodeName string) (string, error) {
+	node, err := kubeClient.CoreV1().Nodes().Get(context.Background(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if node.Spec.PodCIDR == "" {
+		return "", fmt.Errorf("node %s has no PodCIDR assigned", nodeName)
+	}
+	return node.Spec.PodCIDR, nil
+}
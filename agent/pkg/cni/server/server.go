@@ -0,0 +1,113 @@
+// Package server implements the edgemesh-agent side of the CNI shim split:
+// an HTTP handler, served over a Unix domain socket, that receives the
+// ADD/DEL/CHECK requests forwarded by the edgemesh-cni binary and wires
+// pods into the SOCKS5 + tunnel data path.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"k8s.io/klog/v2"
+
+	"github.com/kubeedge/edgemesh/agent/pkg/cni"
+)
+
+// Server serves the edgemesh-agent CNI handler over a Unix domain socket.
+type Server struct {
+	SocketPath string
+	PodCIDR    string
+	MTU        int
+
+	listener net.Listener
+}
+
+// NewServer creates a Server listening on socketPath. Any stale socket file
+// left behind by a previous run is removed first.
+func NewServer(socketPath, podCIDR string, mtu int) (*Server, error) {
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	return &Server{SocketPath: socketPath, PodCIDR: podCIDR, MTU: mtu, listener: listener}, nil
+}
+
+// Start serves CNI requests until the process exits.
+func (s *Server) Start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cni/add", s.handle(s.add))
+	mux.HandleFunc("/cni/del", s.handle(s.del))
+	mux.HandleFunc("/cni/check", s.handle(s.check))
+
+	go func() {
+		if err := http.Serve(s.listener, mux); err != nil {
+			klog.Errorf("cni server stopped serving: %v", err)
+		}
+	}()
+}
+
+func (s *Server) handle(fn func(*cni.CNIRequest) (*cni.CNIResult, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &cni.CNIRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode cni request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		result, err := fn(req)
+		if err != nil {
+			klog.Errorf("cni %s %s failed: %v", req.Command, req.ContainerID, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			klog.Errorf("failed to encode cni result: %v", err)
+		}
+	}
+}
+
+// add creates a veth pair into the pod's netns, assigns it an IP out of
+// PodCIDR and adds a host route for it via the host veth; existing cluster
+// IP interception rules route the pod's outbound traffic through the SOCKS5
+// + tunnel L4 proxy from there.
+func (s *Server) add(req *cni.CNIRequest) (*cni.CNIResult, error) {
+	hostName, iface, ipConfig, err := setupVeth(req.Netns, req.IfName, s.PodCIDR, req.ContainerID, s.MTU)
+	if err != nil {
+		return nil, fmt.Errorf("cni add %s: %w", req.ContainerID, err)
+	}
+
+	if err := addPodHostRoute(hostName, ipConfig.Address); err != nil {
+		return nil, fmt.Errorf("cni add %s: %w", req.ContainerID, err)
+	}
+
+	klog.Infof("cni add %s: attached %s (%s) via gateway %s to %s", req.ContainerID, iface.Name, ipConfig.Address, ipConfig.Gateway, req.Netns)
+	return &cni.CNIResult{
+		CNIVersion: "1.0.0",
+		Interfaces: []cni.Interface{iface},
+		IPs:        []cni.IPConfig{ipConfig},
+		Routes:     []cni.Route{{Dst: "0.0.0.0/0"}},
+	}, nil
+}
+
+// del tears down the veth pair and route created by add, and releases the
+// pod's IP back to the allocator.
+func (s *Server) del(req *cni.CNIRequest) (*cni.CNIResult, error) {
+	if err := teardownVeth(req.IfName, req.ContainerID); err != nil {
+		return nil, fmt.Errorf("cni del %s: %w", req.ContainerID, err)
+	}
+	return &cni.CNIResult{CNIVersion: "1.0.0"}, nil
+}
+
+// check verifies the pod's network plumbing is still in place.
+func (s *Server) check(req *cni.CNIRequest) (*cni.CNIResult, error) {
+	if err := checkVeth(req.Netns, req.IfName); err != nil {
+		return nil, fmt.Errorf("cni check %s: %w", req.ContainerID, err)
+	}
+	return &cni.CNIResult{CNIVersion: "1.0.0"}, nil
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+
+	"github.com/kubeedge/edgemesh/agent/pkg/cni"
+)
+
+// hostVethName derives the host-side veth name from a pod interface name,
+// e.g. "eth0" -> "vetheth0".
+func hostVethName(ifName string) string {
+	return "veth" + ifName
+}
+
+// createVethPair creates a veth pair on the host, named after the pod
+// interface, with mtu applied to both ends.
+func createVethPair(ifName string, mtu int) (hostName, peerName string, err error) {
+	hostName = hostVethName(ifName)
+	peerName = ifName
+	args := []string{"link", "add", hostName, "mtu", fmt.Sprintf("%d", mtu), "type", "veth", "peer", "name", peerName}
+	if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+		return "", "", fmt.Errorf("ip link add failed: %v, output: %s", err, out)
+	}
+	return hostName, peerName, nil
+}
+
+// procNetnsPID matches the /proc/<pid>/ns/net form CNI_NETNS is given in by
+// every CRI edgemesh supports (containerd, CRI-O, dockershim).
+var procNetnsPID = regexp.MustCompile(`^/proc/(\d+)/ns/net$`)
+
+// netnsPID extracts the PID from a /proc/<pid>/ns/net CNI_NETNS path, since
+// "ip link set ... netns" takes a PID or a named netns under /var/run/netns,
+// not an arbitrary namespace path.
+func netnsPID(netnsPath string) (string, error) {
+	m := procNetnsPID.FindStringSubmatch(netnsPath)
+	if m == nil {
+		return "", fmt.Errorf("unsupported netns path %s, expected /proc/<pid>/ns/net", netnsPath)
+	}
+	return m[1], nil
+}
+
+// nsenterCommand runs args inside the namespace at netnsPath. Unlike
+// "ip netns exec", which requires a named namespace registered under
+// /var/run/netns, nsenter opens netnsPath directly, which is what CNI_NETNS
+// actually gives us.
+func nsenterCommand(netnsPath string, args ...string) *exec.Cmd {
+	nsenterArgs := append([]string{"--net=" + netnsPath, "--"}, args...)
+	return exec.Command("nsenter", nsenterArgs...)
+}
+
+// podGatewayIP is the link-local address edgemesh assigns to the host end of
+// every veth pair and routes the pod's default route through, the same
+// fixed-gateway-over-veth trick ptp-style CNI plugins use. It is safe to
+// reuse across every veth pair even though they're all distinct host
+// interfaces: each is a /32 "onlink" nexthop scoped to its own link, not a
+// routable network address.
+const podGatewayIP = "169.254.1.1"
+
+// alreadyExists reports whether out looks like the "ip addr add" failure for
+// an address that's already assigned, which is expected and harmless here
+// since podGatewayIP is added to every veth pair's host end.
+func alreadyExists(out []byte) bool {
+	return bytes.Contains(out, []byte("File exists"))
+}
+
+// setupVeth creates a veth pair, moves the pod-side end into the pod's
+// network namespace, assigns it an address out of podCIDR and brings both
+// ends up. The address is allocated to containerID so a matching
+// teardownVeth can release it.
+func setupVeth(netnsPath, ifName, podCIDR, containerID string, mtu int) (hostName string, iface cni.Interface, ipConfig cni.IPConfig, err error) {
+	hostName, peerName, err := createVethPair(ifName, mtu)
+	if err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, err
+	}
+
+	pid, err := netnsPID(netnsPath)
+	if err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, err
+	}
+
+	if out, err := exec.Command("ip", "link", "set", peerName, "netns", pid).CombinedOutput(); err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, fmt.Errorf("failed to move %s into %s: %v, output: %s", peerName, netnsPath, err, out)
+	}
+	if out, err := exec.Command("ip", "link", "set", hostName, "up").CombinedOutput(); err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, fmt.Errorf("failed to bring up %s: %v, output: %s", hostName, err, out)
+	}
+	if out, err := exec.Command("ip", "addr", "add", podGatewayIP+"/32", "dev", hostName).CombinedOutput(); err != nil && !alreadyExists(out) {
+		return "", cni.Interface{}, cni.IPConfig{}, fmt.Errorf("failed to assign gateway address to %s: %v, output: %s", hostName, err, out)
+	}
+
+	addr, err := allocatePodIP(podCIDR, containerID)
+	if err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, err
+	}
+	podIP, _, err := net.ParseCIDR(addr)
+	if err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, fmt.Errorf("invalid allocated pod ip %s: %w", addr, err)
+	}
+
+	if out, err := nsenterCommand(netnsPath, "ip", "addr", "add", podIP.String()+"/32", "dev", peerName).CombinedOutput(); err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, fmt.Errorf("failed to assign %s to %s: %v, output: %s", addr, peerName, err, out)
+	}
+	if out, err := nsenterCommand(netnsPath, "ip", "link", "set", peerName, "up").CombinedOutput(); err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, fmt.Errorf("failed to bring up %s in %s: %v, output: %s", peerName, netnsPath, err, out)
+	}
+	if out, err := nsenterCommand(netnsPath, "ip", "route", "add", podGatewayIP, "dev", peerName).CombinedOutput(); err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, fmt.Errorf("failed to add gateway route in %s: %v, output: %s", netnsPath, err, out)
+	}
+	if out, err := nsenterCommand(netnsPath, "ip", "route", "add", "default", "via", podGatewayIP, "dev", peerName).CombinedOutput(); err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, fmt.Errorf("failed to add default route in %s: %v, output: %s", netnsPath, err, out)
+	}
+
+	mac, err := interfaceMAC(netnsPath, peerName)
+	if err != nil {
+		return "", cni.Interface{}, cni.IPConfig{}, err
+	}
+
+	return hostName, cni.Interface{Name: peerName, Sandbox: netnsPath, Mac: mac},
+		cni.IPConfig{Address: addr, Gateway: podGatewayIP, Interface: 0}, nil
+}
+
+// interfaceMAC reads the hardware address ip assigned to ifName inside
+// netnsPath, for the CNI Result's Interface.Mac field.
+func interfaceMAC(netnsPath, ifName string) (string, error) {
+	out, err := nsenterCommand(netnsPath, "ip", "-o", "link", "show", ifName).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s mac in %s: %v, output: %s", ifName, netnsPath, err, out)
+	}
+	m := macAddrPattern.FindString(string(out))
+	if m == "" {
+		return "", fmt.Errorf("no mac address found for %s in %s, output: %s", ifName, netnsPath, out)
+	}
+	return m, nil
+}
+
+var macAddrPattern = regexp.MustCompile(`([0-9a-f]{2}:){5}[0-9a-f]{2}`)
+
+// teardownVeth deletes the host end of the veth pair created for ifName;
+// deleting it also removes the peer end inside the pod netns. The address
+// allocated to containerID by setupVeth is released back to the IPAM free
+// list.
+func teardownVeth(ifName, containerID string) error {
+	hostName := hostVethName(ifName)
+	if out, err := exec.Command("ip", "link", "del", hostName).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete %s: %v, output: %s", hostName, err, out)
+	}
+	releasePodIP(containerID)
+	return nil
+}
+
+// checkVeth verifies ifName is still present inside netnsPath.
+func checkVeth(netnsPath, ifName string) error {
+	if out, err := nsenterCommand(netnsPath, "ip", "link", "show", ifName).CombinedOutput(); err != nil {
+		return fmt.Errorf("interface %s not found in %s: %v, output: %s", ifName, netnsPath, err, out)
+	}
+	return nil
+}
+
+// addPodHostRoute adds a host route for the pod's IP via its veth. This only
+// makes the pod reachable from the host's local routing table; it does not
+// by itself wire the pod into the SOCKS5/tunnel data path, which is handled
+// by the existing L4 proxy interception rules on the node.
+func addPodHostRoute(hostVeth, podIPWithPrefix string) error {
+	ip, _, err := net.ParseCIDR(podIPWithPrefix)
+	if err != nil {
+		return fmt.Errorf("invalid pod ip %s: %w", podIPWithPrefix, err)
+	}
+	if out, err := exec.Command("ip", "route", "replace", ip.String(), "dev", hostVeth).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add route for %s via %s: %v, output: %s", ip, hostVeth, err, out)
+	}
+	return nil
+}
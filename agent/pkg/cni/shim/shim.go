@@ -0,0 +1,102 @@
+// Package shim implements the edgemesh-cni binary: a thin CNI plugin that
+// forwards every ADD/DEL/CHECK invocation it receives from kubelet to the
+// long-running edgemesh-agent over a Unix domain socket, following the
+// cnishim/cniserver split used by other host-local CNI meta-plugins. All of
+// the real network plumbing lives in agent/pkg/cni/server, which runs
+// in-process inside edgemesh-agent.
+package shim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/kubeedge/edgemesh/agent/pkg/cni"
+)
+
+// DefaultSocketPath is used when CNI_EDGEMESH_SOCKET is not set in the
+// plugin's environment; it must match EdgeMeshAgentOptions' CNISocketPath
+// (the edgemesh-agent --cni-socket-path flag).
+const DefaultSocketPath = "/run/edgemesh/cni.sock"
+
+// Run packages the CNI stdin/env contract kubelet invoked us with into a
+// cni.CNIRequest, POSTs it to the edgemesh-agent CNI handler, and prints the
+// returned CNIResult to stdout the way kubelet expects; for DEL nothing is
+// printed.
+func Run() error {
+	command := os.Getenv("CNI_COMMAND")
+	if command == "" {
+		return fmt.Errorf("CNI_COMMAND is not set")
+	}
+
+	stdinData, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read stdin: %w", err)
+	}
+
+	req := &cni.CNIRequest{
+		Command:     command,
+		ContainerID: os.Getenv("CNI_CONTAINERID"),
+		Netns:       os.Getenv("CNI_NETNS"),
+		IfName:      os.Getenv("CNI_IFNAME"),
+		Args:        os.Getenv("CNI_ARGS"),
+		Path:        os.Getenv("CNI_PATH"),
+		StdinData:   stdinData,
+	}
+
+	socketPath := os.Getenv("CNI_EDGEMESH_SOCKET")
+	if socketPath == "" {
+		socketPath = DefaultSocketPath
+	}
+
+	result, err := post(socketPath, req)
+	if err != nil {
+		return err
+	}
+	if command == "DEL" {
+		return nil
+	}
+
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
+
+// post sends req to the edgemesh-agent CNI handler over a Unix domain
+// socket and decodes its CNIResult response.
+func post(socketPath string, req *cni.CNIRequest) (*cni.CNIResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cni request: %w", err)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", socketPath)
+			},
+		},
+	}
+
+	url := fmt.Sprintf("http://unix/cni/%s", strings.ToLower(req.Command))
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to call edgemesh-agent cni handler: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("edgemesh-agent cni handler returned %d: %s", resp.StatusCode, msg)
+	}
+
+	result := &cni.CNIResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("failed to decode cni result: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,56 @@
+// Package cni holds the types shared between the edgemesh-cni shim binary
+// and the CNI handler registered inside the running edgemesh-agent.
+package cni
+
+// CNIRequest is the JSON payload edgemesh-cni POSTs to the agent's CNI
+// handler for each invocation. It carries exactly what kubelet passes the
+// plugin over the CNI stdin/env contract, so the handler can run the real
+// network plumbing without the shim linking any netns/netlink code itself.
+type CNIRequest struct {
+	// Command is one of ADD, DEL, CHECK.
+	Command string `json:"command"`
+	// ContainerID is CNI_CONTAINERID.
+	ContainerID string `json:"containerID"`
+	// Netns is CNI_NETNS, the path to the pod network namespace.
+	Netns string `json:"netns"`
+	// IfName is CNI_IFNAME, the interface name to create inside the netns.
+	IfName string `json:"ifName"`
+	// Args is CNI_ARGS, e.g. "K8S_POD_NAMESPACE=foo;K8S_POD_NAME=bar".
+	Args string `json:"args"`
+	// Path is CNI_PATH, the search path for CNI plugin binaries.
+	Path string `json:"path"`
+	// StdinData is the raw network configuration edgemesh-cni received on stdin.
+	StdinData []byte `json:"stdinData"`
+}
+
+// CNIResult mirrors the subset of the CNI Result type edgemesh-cni needs to
+// print back to kubelet on stdout.
+type CNIResult struct {
+	CNIVersion string      `json:"cniVersion"`
+	Interfaces []Interface `json:"interfaces,omitempty"`
+	IPs        []IPConfig  `json:"ips,omitempty"`
+	Routes     []Route     `json:"routes,omitempty"`
+}
+
+// Interface describes one network interface created for the pod.
+type Interface struct {
+	Name    string `json:"name"`
+	Mac     string `json:"mac,omitempty"`
+	Sandbox string `json:"sandbox,omitempty"`
+}
+
+// IPConfig describes one IP address assigned to an Interface. Interface is
+// the index into CNIResult.Interfaces the address belongs to, and Gateway is
+// the address traffic leaving the pod via this IP should be routed through;
+// Gateway is left out of the JSON when empty.
+type IPConfig struct {
+	Address   string `json:"address"`
+	Gateway   string `json:"gateway,omitempty"`
+	Interface int    `json:"interface"`
+}
+
+// Route describes one route to install in the pod's network namespace.
+type Route struct {
+	Dst string `json:"dst"`
+	GW  string `json:"gw,omitempty"`
+}
@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CorefileBuilder assembles a single CoreDNS Corefile server block
+// programmatically. It replaces the old text/template rendering so other
+// modules (e.g. a future service-mesh sidecar) can compose plugin blocks
+// with typed method calls instead of string templating.
+type CorefileBuilder struct {
+	domain  string
+	port    string
+	plugins []string
+}
+
+// NewCorefileBuilder starts a builder for the "domain:port { ... }" server
+// block.
+func NewCorefileBuilder(domain, port string) *CorefileBuilder {
+	return &CorefileBuilder{domain: domain, port: port}
+}
+
+// Bind adds one "bind" plugin line per address, so dual-stack listeners get
+// one line per family instead of a single combined one.
+func (b *CorefileBuilder) Bind(ips ...string) *CorefileBuilder {
+	for _, ip := range ips {
+		b.plugins = append(b.plugins, fmt.Sprintf("bind %s", ip))
+	}
+	return b
+}
+
+// Cache adds the "cache" plugin with the given TTL in seconds.
+func (b *CorefileBuilder) Cache(ttl int) *CorefileBuilder {
+	b.plugins = append(b.plugins, fmt.Sprintf("cache %d", ttl))
+	return b
+}
+
+// Errors adds the "errors" plugin.
+func (b *CorefileBuilder) Errors() *CorefileBuilder {
+	b.plugins = append(b.plugins, "errors")
+	return b
+}
+
+// Forward adds a "forward" plugin forwarding "." to upstreams over TCP.
+func (b *CorefileBuilder) Forward(upstreams ...string) *CorefileBuilder {
+	b.plugins = append(b.plugins, fmt.Sprintf("forward . %s {\n    force_tcp\n}", strings.Join(upstreams, " ")))
+	return b
+}
+
+// Kubernetes adds the "kubernetes" plugin block built from info.
+func (b *CorefileBuilder) Kubernetes(info *KubernetesPluginInfo) *CorefileBuilder {
+	b.plugins = append(b.plugins, fmt.Sprintf("kubernetes cluster.local in-addr.arpa ip6.arpa {\n    %s\n    pods insecure\n    fallthrough in-addr.arpa ip6.arpa\n    ttl %d\n}", info.APIServer, info.TTL))
+	return b
+}
+
+// Plugin appends an arbitrary, already-rendered plugin block. This is the
+// escape hatch for blocks the builder has no dedicated method for.
+func (b *CorefileBuilder) Plugin(block string) *CorefileBuilder {
+	if block != "" {
+		b.plugins = append(b.plugins, block)
+	}
+	return b
+}
+
+// Log adds the "log" plugin.
+func (b *CorefileBuilder) Log() *CorefileBuilder {
+	b.plugins = append(b.plugins, "log")
+	return b
+}
+
+// Loop adds the "loop" plugin.
+func (b *CorefileBuilder) Loop() *CorefileBuilder {
+	b.plugins = append(b.plugins, "loop")
+	return b
+}
+
+// Reload adds the "reload" plugin, which lets a running CoreDNS instance
+// pick up Corefile changes on SIGUSR1 without a full process restart.
+func (b *CorefileBuilder) Reload() *CorefileBuilder {
+	b.plugins = append(b.plugins, "reload")
+	return b
+}
+
+// Build renders the accumulated plugin blocks into a single server block.
+func (b *CorefileBuilder) Build() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s:%s {\n", b.domain, b.port)
+	for _, plugin := range b.plugins {
+		for _, line := range strings.Split(plugin, "\n") {
+			fmt.Fprintf(&out, "    %s\n", line)
+		}
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
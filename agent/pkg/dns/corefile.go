@@ -1,10 +1,8 @@
 package dns
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"net"
 	"strconv"
@@ -41,50 +39,31 @@ import (
 	"github.com/kubeedge/edgemesh/common/util"
 )
 
-// copy from https://github.com/kubernetes/dns/blob/1.21.0/cmd/node-cache/app/configmap.go and update
 const (
-	stubDomainBlock = `{{.DomainName}}:{{.Port}} {
-    bind {{.LocalIP}}
-    cache {{.CacheTTL}}
-    errors
-    forward . {{.UpstreamServers}} {
-        force_tcp
-    }
-    {{ .KubernetesPlugin }}
-    log
-    loop
-    reload
-}
-`
-	kubernetesPluginBlock = `kubernetes cluster.local in-addr.arpa ip6.arpa {
-        {{ .APIServer }}
-        pods insecure
-        fallthrough in-addr.arpa ip6.arpa
-        ttl {{ .TTL }}
-    }`
 	defaultTTL            = 30
 	defaultUpstreamServer = "/etc/resolv.conf"
 	corefilePath          = "Corefile"
 )
 
-// copy from https://github.com/kubernetes/dns/blob/1.21.0/cmd/node-cache/app/configmap.go and update
-// stubDomainInfo contains all the parameters needed to compute
-// a stubDomain block in the Corefile.
-type stubDomainInfo struct {
-	DomainName       string
-	LocalIP          string
-	Port             string
-	CacheTTL         int
-	UpstreamServers  string
-	KubernetesPlugin string
-}
+// IP family policies accepted by EdgeMeshAgentConfig.IPFamilyPolicy, mirroring
+// the values of Kubernetes' own Service.Spec.IPFamilyPolicy.
+const (
+	IPv4      = "IPv4"
+	IPv6      = "IPv6"
+	DualStack = "DualStack"
+)
 
+// KubernetesPluginInfo carries the parameters needed to render the
+// "kubernetes" CoreDNS plugin block.
 type KubernetesPluginInfo struct {
 	APIServer string
 	TTL       int
 }
 
-func getKubernetesPluginStr(cfg *config.EdgeDNSConfig) (string, error) {
+// buildKubernetesPluginInfo works out the apiserver endpoint the kubernetes
+// plugin should talk to, which differs between debug mode (a kubeconfig or
+// explicit master URL) and edge mode (the local edged API proxy).
+func buildKubernetesPluginInfo(cfg *config.EdgeDNSConfig) *KubernetesPluginInfo {
 	var apiServer string
 	if cfg.Mode == appconfig.DebugMode {
 		if cfg.KubeAPIConfig.Master != "" {
@@ -97,59 +76,44 @@ func getKubernetesPluginStr(cfg *config.EdgeDNSConfig) (string, error) {
 	} else if cfg.Mode == appconfig.EdgeMode {
 		apiServer = fmt.Sprintf("endpoint %s", appconfig.DefaultEdgeApiServer)
 	}
-
-	info := &KubernetesPluginInfo{
-		APIServer: apiServer,
-		TTL:       defaultTTL,
-	}
-	var tpl bytes.Buffer
-	tmpl, err := template.New("kubernetesPluginBlock").Parse(kubernetesPluginBlock)
-	if err != nil {
-		return "", fmt.Errorf("failed to create kubernetesPlugin template, err : %w", err)
-	}
-	if err := tmpl.Execute(&tpl, *info); err != nil {
-		return "", fmt.Errorf("failed to create kubernetesPlugin template, err : %w", err)
-	}
-	return tpl.String(), nil
+	return &KubernetesPluginInfo{APIServer: apiServer, TTL: defaultTTL}
 }
 
-// copy from https://github.com/kubernetes/dns/blob/1.21.0/cmd/node-cache/app/configmap.go and update
-func getStubDomainStr(stubDomainMap map[string][]string, info *stubDomainInfo) (string, error) {
-	var tpl bytes.Buffer
-	for domainName, servers := range stubDomainMap {
-		tmpl, err := template.New("stubDomainBlock").Parse(stubDomainBlock)
+// UpdateCorefile renders the Corefile for cfg's "." server block and writes
+// it to corefilePath. Call it again (see reload.go) whenever cfg, the
+// cluster's coredns/kube-dns Services, or ListenInterface's address change.
+func UpdateCorefile(cfg *config.EdgeDNSConfig, ifm *informers.Manager) error {
+	// ListenInterface is normally auto-detected and persisted into cfg by
+	// EdgeMeshAgentOptions.Config; fall back to resolving it here too so a
+	// hot-reload (see reload.go) with a stale empty value still works.
+	ifaceName := cfg.ListenInterface
+	if ifaceName == "" {
+		resolved, err := util.ChooseHostInterface()
 		if err != nil {
-			return "", fmt.Errorf("failed to create stubDomain template, err : %w", err)
-		}
-		info.DomainName = domainName
-		info.UpstreamServers = strings.Join(servers, " ")
-		if err := tmpl.Execute(&tpl, *info); err != nil {
-			return "", fmt.Errorf("failed to create stubDomain template, err : %w", err)
+			return fmt.Errorf("failed to auto-detect listen interface: %w", err)
 		}
+		ifaceName = resolved
 	}
-	return tpl.String(), nil
-}
 
-// copy from https://github.com/kubernetes/dns/blob/1.21.0/cmd/node-cache/app/configmap.go and update
-func UpdateCorefile(cfg *config.EdgeDNSConfig, ifm *informers.Manager) error {
-	// get listen ip
-	ListenIP, err := util.GetInterfaceIP(cfg.ListenInterface)
+	// get listen ips, one per family the interface owns
+	listenIPs, err := util.GetInterfaceIPs(ifaceName)
 	if err != nil {
 		return err
 	}
+	localIPs := filterByFamily(ipsToStrings(listenIPs), cfg.IPFamilyPolicy)
+	if len(localIPs) == 0 {
+		return fmt.Errorf("no address on interface %s matches IPFamilyPolicy %s", ifaceName, cfg.IPFamilyPolicy)
+	}
 
 	cacheTTL := defaultTTL
 	upstreamServers := []string{defaultUpstreamServer}
-	kubernetesPlugin, err := getKubernetesPluginStr(cfg)
-	if err != nil {
-		return err
-	}
+	useKubernetesPlugin := true
 
 	if cfg.CacheDNS.Enable {
 		// Reset upstream server
 		upstreamServers = []string{}
 		if cfg.CacheDNS.AutoDetect {
-			upstreamServers = append(upstreamServers, detectClusterDNS(ifm.GetKubeClient())...)
+			upstreamServers = append(upstreamServers, filterByFamily(detectClusterDNS(ifm.GetKubeClient()), cfg.IPFamilyPolicy)...)
 		}
 		for _, server := range cfg.CacheDNS.UpstreamServers {
 			server = strings.TrimSpace(server)
@@ -170,24 +134,20 @@ func UpdateCorefile(cfg *config.EdgeDNSConfig, ifm *informers.Manager) error {
 		}
 		cacheTTL = cfg.CacheDNS.CacheTTL
 		// Disable coredns kubernetes plugin.
-		kubernetesPlugin = ""
+		useKubernetesPlugin = false
 	}
 
-	stubDomainMap := make(map[string][]string)
-	stubDomainMap["."] = upstreamServers
-	stubDomainStr, err := getStubDomainStr(stubDomainMap, &stubDomainInfo{
-		LocalIP:          ListenIP.String(),
-		Port:             fmt.Sprintf("%d", cfg.ListenPort),
-		CacheTTL:         cacheTTL,
-		KubernetesPlugin: kubernetesPlugin,
-	})
-	if err != nil {
-		return err
+	builder := NewCorefileBuilder(".", fmt.Sprintf("%d", cfg.ListenPort)).
+		Bind(localIPs...).
+		Cache(cacheTTL).
+		Errors().
+		Forward(upstreamServers...)
+	if useKubernetesPlugin {
+		builder.Kubernetes(buildKubernetesPluginInfo(cfg))
 	}
+	builder.Log().Loop().Reload()
 
-	newConfig := bytes.Buffer{}
-	newConfig.WriteString(stubDomainStr)
-	if err := ioutil.WriteFile(corefilePath, newConfig.Bytes(), 0666); err != nil {
+	if err := ioutil.WriteFile(corefilePath, []byte(builder.Build()), 0666); err != nil {
 		return fmt.Errorf("failed to write %s, err %w", corefilePath, err)
 	}
 
@@ -196,19 +156,17 @@ func UpdateCorefile(cfg *config.EdgeDNSConfig, ifm *informers.Manager) error {
 
 func detectClusterDNS(kubeClient kubernetes.Interface) (servers []string) {
 	coredns, err := kubeClient.CoreV1().Services("kube-system").Get(context.Background(), "coredns", metav1.GetOptions{})
-	if err == nil && coredns.Spec.ClusterIP != v1.ClusterIPNone {
-		servers = append(servers, coredns.Spec.ClusterIP)
+	if err == nil {
+		servers = append(servers, clusterIPs(coredns)...)
 	}
 	kubedns, err := kubeClient.CoreV1().Services("kube-system").Get(context.Background(), "kube-dns", metav1.GetOptions{})
-	if err == nil && kubedns.Spec.ClusterIP != v1.ClusterIPNone {
-		servers = append(servers, kubedns.Spec.ClusterIP)
+	if err == nil {
+		servers = append(servers, clusterIPs(kubedns)...)
 	}
 	kubeDNSList, err := kubeClient.CoreV1().Services("kube-system").List(context.Background(), metav1.ListOptions{LabelSelector: "k8s-app=kube-dns"})
 	if err == nil {
-		for _, item := range kubeDNSList.Items {
-			if item.Spec.ClusterIP != v1.ClusterIPNone {
-				servers = append(servers, item.Spec.ClusterIP)
-			}
+		for i := range kubeDNSList.Items {
+			servers = append(servers, clusterIPs(&kubeDNSList.Items[i])...)
 		}
 	}
 	servers = removeDuplicate(servers)
@@ -220,14 +178,28 @@ func detectClusterDNS(kubeClient kubernetes.Interface) (servers []string) {
 	return servers
 }
 
+// clusterIPs returns every cluster IP a Service has, across both IP
+// families for dual-stack services, falling back to the single-stack
+// ClusterIP field for older clusters that don't populate ClusterIPs.
+func clusterIPs(svc *v1.Service) (ips []string) {
+	for _, ip := range svc.Spec.ClusterIPs {
+		if ip != "" && ip != v1.ClusterIPNone {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 && svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != v1.ClusterIPNone {
+		ips = append(ips, svc.Spec.ClusterIP)
+	}
+	return ips
+}
+
 func isValidAddress(addr string) bool {
-	items := strings.Split(addr, ":")
-	if len(items) == 1 {
-		return isValidIP(items[0])
-	} else if len(items) == 2 {
-		return isValidIP(items[0]) && isValidPort(items[1])
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// no port, e.g. a bare "1.2.3.4" or "::1"
+		return isValidIP(addr)
 	}
-	return false
+	return isValidIP(host) && isValidPort(port)
 }
 
 func isValidIP(ip string) bool {
@@ -245,6 +217,31 @@ func isValidPort(port string) bool {
 	return false
 }
 
+// ipsToStrings renders a list of net.IP in their string form.
+func ipsToStrings(ips []net.IP) []string {
+	ss := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		ss = append(ss, ip.String())
+	}
+	return ss
+}
+
+// filterByFamily keeps only the addresses matching policy (IPv4 or IPv6);
+// DualStack, or an unset policy, passes every address through unchanged.
+func filterByFamily(ips []string, policy string) []string {
+	if policy == "" || policy == DualStack {
+		return ips
+	}
+	filtered := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		isV4 := net.ParseIP(ip).To4() != nil
+		if (policy == IPv4 && isV4) || (policy == IPv6 && !isV4) {
+			filtered = append(filtered, ip)
+		}
+	}
+	return filtered
+}
+
 func removeDuplicate(ss []string) []string {
 	ret := make([]string, 0)
 	tmp := make(map[string]struct{})
@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/kubeedge/edgemesh/agent/pkg/dns/config"
+	"github.com/kubeedge/edgemesh/common/informers"
+)
+
+var reloadMu sync.Mutex
+
+// StartCorefileWatcher re-renders the Corefile whenever the cluster's
+// coredns/kube-dns Services change, or ListenInterface gets a new address,
+// and reloads CoreDNS so the change takes effect without restarting the
+// agent. It returns once watches are registered. stopCh ends the address
+// subscription; the Service event handler stays registered on ifm's
+// informer.
+//
+// It watches through ifm's shared Service informer rather than standing up
+// a factory of its own, so it adds no second Service watch of its own.
+func StartCorefileWatcher(cfg *config.EdgeDNSConfig, ifm *informers.Manager, stopCh chan struct{}) error {
+	ifm.ServiceInformer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { onDNSServiceChange(obj, cfg, ifm) },
+		UpdateFunc: func(_, obj interface{}) { onDNSServiceChange(obj, cfg, ifm) },
+		DeleteFunc: func(obj interface{}) { onDNSServiceChange(obj, cfg, ifm) },
+	})
+
+	addrCh := make(chan netlink.AddrUpdate)
+	if err := netlink.AddrSubscribe(addrCh, stopCh); err != nil {
+		return fmt.Errorf("failed to subscribe to address changes: %w", err)
+	}
+	go func() {
+		for update := range addrCh {
+			iface, err := net.InterfaceByIndex(update.LinkIndex)
+			if err != nil || iface.Name != cfg.ListenInterface {
+				continue
+			}
+			reload(cfg, ifm)
+		}
+	}()
+
+	return nil
+}
+
+// onDNSServiceChange re-renders the Corefile when the Service that changed
+// is coredns/kube-dns itself, ignoring unrelated Services. The shared
+// informer watches every namespace, so the kube-system check has to happen
+// here rather than via a factory scoped to it.
+func onDNSServiceChange(obj interface{}, cfg *config.EdgeDNSConfig, ifm *informers.Manager) {
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		return
+	}
+	if svc.Namespace != "kube-system" {
+		return
+	}
+	if svc.Name != "coredns" && svc.Name != "kube-dns" && svc.Labels["k8s-app"] != "kube-dns" {
+		return
+	}
+	reload(cfg, ifm)
+}
+
+// reload re-renders the Corefile and signals the running CoreDNS process to
+// pick it up via the "reload" plugin every server block already carries.
+func reload(cfg *config.EdgeDNSConfig, ifm *informers.Manager) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if err := UpdateCorefile(cfg, ifm); err != nil {
+		klog.Errorf("failed to re-render Corefile: %v", err)
+		return
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		klog.Errorf("failed to signal coredns reload plugin: %v", err)
+	}
+}
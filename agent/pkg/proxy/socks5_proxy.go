@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 
@@ -27,6 +28,10 @@ const (
 
 	// DefaultMethod is No certification required
 	DefaultMethod byte = 0x00
+	// UserPassMethod is username/password authentication, see RFC 1929
+	UserPassMethod byte = 0x02
+	// NoAcceptableMethod means none of the client's offered methods are supported
+	NoAcceptableMethod byte = 0xFF
 
 	Success byte = 0x00
 
@@ -39,6 +44,14 @@ const (
 
 	// CmdConnect is connect command
 	CmdConnect byte = 0x01
+	// CmdUDPAssociate is udp associate command
+	CmdUDPAssociate byte = 0x03
+
+	// userPassAuthVersion is the sub-negotiation version defined by RFC 1929
+	userPassAuthVersion byte = 0x01
+	// authSuccess/authFailure are the status bytes of the sub-negotiation reply
+	authSuccess byte = 0x00
+	authFailure byte = 0x01
 )
 
 // DefaultResponse is Socks5 returns data by default
@@ -56,10 +69,18 @@ type Request struct {
 
 type SocksHandle struct {
 	Request *Request
+	// Username and Password hold the RFC 1929 credentials required to pass
+	// handshake, sourced from the EdgeMeshAgent config file. When Username
+	// is empty, no authentication is required.
+	Username string
+	Password string
 }
 
 type Socks5Proxy struct {
-	TCPProxy    *protocol.TCPProxy
+	// TCPProxies holds one listener per address family NewSocks5Proxy was
+	// asked to bind, so dual-stack and IPv6-only clusters get a usable
+	// SOCKS5 listener instead of a single IPv4 one.
+	TCPProxies  []*protocol.TCPProxy
 	kubeClient  kubernetes.Interface
 	NodeName    string
 	SocksHandle *SocksHandle
@@ -101,25 +122,68 @@ func (s *SocksHandle) handShake(conn net.Conn) (err error) {
 		return err
 	}
 
+	wantMethod := DefaultMethod
+	if s.Username != "" {
+		wantMethod = UserPassMethod
+	}
+
 	flag := false
-	var m byte
-	for _, m = range ms {
-		if m == DefaultMethod {
+	for _, m := range ms {
+		if m == wantMethod {
 			flag = true
+			break
 		}
 	}
 	if !flag {
+		_, _ = conn.Write([]byte{Version, NoAcceptableMethod})
 		return fmt.Errorf("this method is not yet supported")
 	}
 
-	_, err = conn.Write([]byte{Version, Success})
-	if err != nil {
+	if _, err = conn.Write([]byte{Version, wantMethod}); err != nil {
 		return err
 	}
 
+	if wantMethod == UserPassMethod {
+		return s.authenticate(conn)
+	}
+
 	return nil
 }
 
+// authenticate implements the RFC 1929 username/password sub-negotiation:
+// VER, ULEN, UNAME, PLEN, PASSWD in, and a VER/STATUS reply out.
+func (s *SocksHandle) authenticate(conn net.Conn) (err error) {
+	header := make([]byte, 2)
+	if _, err = conn.Read(header); err != nil {
+		return err
+	}
+	if header[0] != userPassAuthVersion {
+		return fmt.Errorf("invalid auth version")
+	}
+
+	uname := make([]byte, int(header[1]))
+	if _, err = conn.Read(uname); err != nil {
+		return err
+	}
+
+	plen := make([]byte, 1)
+	if _, err = conn.Read(plen); err != nil {
+		return err
+	}
+	passwd := make([]byte, int(plen[0]))
+	if _, err = conn.Read(passwd); err != nil {
+		return err
+	}
+
+	if string(uname) != s.Username || string(passwd) != s.Password {
+		_, _ = conn.Write([]byte{userPassAuthVersion, authFailure})
+		return fmt.Errorf("invalid username or password")
+	}
+
+	_, err = conn.Write([]byte{userPassAuthVersion, authSuccess})
+	return err
+}
+
 // copy from https://github.com/txthinking/socks5/blob/e03c1217a50bd1363a2aaf58290da622256704fa/server_side.go#L125 and update
 func (s *SocksHandle) NewRequest(conn net.Conn) (err error) {
 	data := make([]byte, 4)
@@ -178,30 +242,43 @@ func (s *SocksHandle) NewRequest(conn net.Conn) (err error) {
 }
 
 func (s *Socks5Proxy) Start() {
-	go func() {
-		for {
-			conn, err := s.TCPProxy.Listener.Accept()
-			if err != nil {
-				klog.Warningf("get socks5 tcp conn error: %v", err)
-				continue
+	for _, tcpProxy := range s.TCPProxies {
+		tcpProxy := tcpProxy
+		go func() {
+			for {
+				conn, err := tcpProxy.Listener.Accept()
+				if err != nil {
+					klog.Warningf("get socks5 tcp conn error: %v", err)
+					continue
+				}
+				go s.HandleSocksProxy(conn)
 			}
-			go s.HandleSocksProxy(conn)
-		}
-	}()
+		}()
+	}
 }
 
-func NewSocks5Proxy(ip net.IP, port int, NodeName string, kubeClient kubernetes.Interface) (socks5Proxy *Socks5Proxy, err error) {
+// NewSocks5Proxy creates a Socks5Proxy listening on every address in ips
+// (IPv4, IPv6, or both for dual-stack), so IPv6-only clusters get a usable
+// SOCKS5 listener instead of the single IPv4 bind this used to be limited
+// to. When username is non-empty, clients are required to authenticate via
+// RFC 1929 username/password before any request is served.
+func NewSocks5Proxy(ips []net.IP, port int, NodeName string, kubeClient kubernetes.Interface, username, password string) (socks5Proxy *Socks5Proxy, err error) {
 	socks := &Socks5Proxy{
 		kubeClient: kubeClient,
-		TCPProxy:   &protocol.TCPProxy{Name: protocol.TCP},
 		NodeName:   NodeName,
 		SocksHandle: &SocksHandle{
-			Request: &Request{},
+			Request:  &Request{},
+			Username: username,
+			Password: password,
 		},
 	}
 
-	if err := socks.TCPProxy.SetListener(ip, port); err != nil {
-		return socks, fmt.Errorf("set socks5 proxy err: %v, host: %s, port: %d", err, ip, port)
+	for _, ip := range ips {
+		tcpProxy := &protocol.TCPProxy{Name: protocol.TCP}
+		if err := tcpProxy.SetListener(ip, port); err != nil {
+			return socks, fmt.Errorf("set socks5 proxy err: %v, host: %s, port: %d", err, ip, port)
+		}
+		socks.TCPProxies = append(socks.TCPProxies, tcpProxy)
 	}
 	return socks, nil
 }
@@ -219,6 +296,11 @@ func (s *Socks5Proxy) HandleSocksProxy(conn net.Conn) {
 		return
 	}
 
+	if s.SocksHandle.Request.Command == CmdUDPAssociate {
+		s.handleUDPAssociate(conn)
+		return
+	}
+
 	if s.SocksHandle.Request.AddressType != ATYPDomain || s.SocksHandle.Request.DstAddr == s.NodeName {
 		klog.Warningf("Connecting to the local computer and connecting via IP are not supported. host: %s, port: %d, localNodeName: %s", s.SocksHandle.Request.DstAddr, s.SocksHandle.Request.DstPort, s.NodeName)
 		return
@@ -238,6 +320,191 @@ func (s *Socks5Proxy) HandleSocksProxy(conn net.Conn) {
 	}
 }
 
+// handleUDPAssociate implements the SOCKS5 UDP ASSOCIATE command. It opens a
+// UDP relay socket matching the control connection's address family,
+// reports it back to the client, then shuttles datagrams between the client
+// and one tunnel stream per destination. The SOCKS5 UDP request header (RSV,
+// FRAG, ATYP, DST.ADDR, DST.PORT) of each client datagram is stripped and
+// only the payload is written to the stream; the destination travels in the
+// stream's ProxyOptions, not in the stream data. Replies read from the
+// stream get the header prepended before they are sent to the client. A
+// single association is kept open for
+// the lifetime of the control connection and may carry datagrams to several
+// destinations (e.g. a DNS resolver querying multiple upstreams), so streams
+// are keyed by destination host:port rather than reusing the first one.
+func (s *Socks5Proxy) handleUDPAssociate(conn net.Conn) {
+	localAddr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		klog.Errorf("udp associate: unexpected control connection address type")
+		return
+	}
+
+	// Bind the relay socket on the same address family as the control
+	// connection, so IPv6-only clients get an IPv6 relay.
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localAddr.IP, Port: 0})
+	if err != nil {
+		klog.Errorf("udp associate: failed to open relay socket: %v", err)
+		return
+	}
+	defer relayConn.Close()
+
+	bndAddr, ok := relayConn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		klog.Errorf("udp associate: unexpected relay local address type")
+		return
+	}
+	reply := append([]byte{Version, Success, 0x00}, encodeAddrPort(bndAddr.IP.String(), bndAddr.Port)...)
+	if _, err := conn.Write(reply); err != nil {
+		klog.Errorf("udp associate: failed to send reply: %v", err)
+		return
+	}
+
+	// The TCP control connection must stay open for the lifetime of the
+	// association; once the client closes it, tear down the relay socket.
+	go func() {
+		buf := make([]byte, 1)
+		_, _ = conn.Read(buf)
+		relayConn.Close()
+	}()
+
+	var clientAddr *net.UDPAddr
+	streams := make(map[string]io.ReadWriteCloser)
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := relayConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if clientAddr == nil {
+			clientAddr = addr
+		}
+
+		host, port, headerLen, err := parseUDPHeader(buf[:n])
+		if err != nil {
+			klog.Warningf("udp associate: bad datagram from %v: %v", addr, err)
+			continue
+		}
+
+		dstKey := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+		stream, ok := streams[dstKey]
+		if !ok {
+			targetIP, err := s.getTargetIpByNodeName(host)
+			if err != nil {
+				klog.Errorf("udp associate: unable to get destination IP for %s: %v", host, err)
+				continue
+			}
+			stream, err = tunnel.Agent.ProxySvc.GetProxyStream(proxy.ProxyOptions{
+				Protocol: "udp",
+				NodeName: host,
+				IP:       targetIP,
+				Port:     int32(port),
+			})
+			if err != nil {
+				klog.Errorf("udp associate: get proxy stream to %s error: %v", host, err)
+				continue
+			}
+			streams[dstKey] = stream
+			go relayStreamToUDPClient(stream, relayConn, clientAddr, host, port)
+		}
+
+		if _, err := stream.Write(buf[headerLen:n]); err != nil {
+			klog.Errorf("udp associate: write to stream error: %v", err)
+			stream.Close()
+			delete(streams, dstKey)
+			continue
+		}
+	}
+}
+
+// relayStreamToUDPClient reads datagrams sent back through the tunnel stream
+// and forwards them to the SOCKS5 client, re-framing each one with the UDP
+// request header.
+func relayStreamToUDPClient(stream io.ReadWriteCloser, relayConn *net.UDPConn, clientAddr *net.UDPAddr, host string, port int) {
+	defer stream.Close()
+	header := buildUDPHeader(host, port)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := stream.Read(buf)
+		if err != nil {
+			return
+		}
+		packet := append(append([]byte{}, header...), buf[:n]...)
+		if _, err := relayConn.WriteToUDP(packet, clientAddr); err != nil {
+			return
+		}
+	}
+}
+
+// parseUDPHeader parses the SOCKS5 UDP request header (RSV, FRAG, ATYP,
+// DST.ADDR, DST.PORT) from the front of a client datagram, returning the
+// destination host, port and the number of header bytes consumed.
+func parseUDPHeader(data []byte) (host string, port int, headerLen int, err error) {
+	if len(data) < 4 {
+		return "", 0, 0, fmt.Errorf("datagram too short")
+	}
+	if data[2] != 0x00 {
+		return "", 0, 0, fmt.Errorf("fragmentation is not supported")
+	}
+
+	switch data[3] {
+	case ATYPIPv4:
+		if len(data) < 10 {
+			return "", 0, 0, fmt.Errorf("datagram too short for ipv4 address")
+		}
+		host = net.IP(data[4:8]).String()
+		port = int(binary.BigEndian.Uint16(data[8:10]))
+		headerLen = 10
+	case ATYPIPv6:
+		if len(data) < 22 {
+			return "", 0, 0, fmt.Errorf("datagram too short for ipv6 address")
+		}
+		host = net.IP(data[4:20]).String()
+		port = int(binary.BigEndian.Uint16(data[20:22]))
+		headerLen = 22
+	case ATYPDomain:
+		if len(data) < 5 {
+			return "", 0, 0, fmt.Errorf("datagram too short for domain length")
+		}
+		dl := int(data[4])
+		if len(data) < 5+dl+2 {
+			return "", 0, 0, fmt.Errorf("datagram too short for domain address")
+		}
+		host = string(data[5 : 5+dl])
+		port = int(binary.BigEndian.Uint16(data[5+dl : 5+dl+2]))
+		headerLen = 5 + dl + 2
+	default:
+		return "", 0, 0, fmt.Errorf("unsupported address type: %d", data[3])
+	}
+	return host, port, headerLen, nil
+}
+
+// buildUDPHeader builds a SOCKS5 UDP request header for a reply datagram
+// being relayed back to the client.
+func buildUDPHeader(host string, port int) []byte {
+	return append([]byte{0x00, 0x00, 0x00}, encodeAddrPort(host, port)...)
+}
+
+// encodeAddrPort encodes host and port as a SOCKS5 ATYP/address/port triple,
+// picking IPv4, IPv6 or domain encoding based on host's form. It is the
+// shared tail of both the UDP request header and the UDP ASSOCIATE reply.
+func encodeAddrPort(host string, port int) []byte {
+	var b []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, ATYPIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, ATYPIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		b = append(b, ATYPDomain, byte(len(host)))
+		b = append(b, []byte(host)...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+	return b
+}
+
 func proxyConnectToRemote(host string, targetIP string, port int32, conn net.Conn) {
 	proxyOpts := proxy.ProxyOptions{
 		Protocol: "tcp",
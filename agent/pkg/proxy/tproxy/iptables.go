@@ -0,0 +1,137 @@
+package tproxy
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// TableMangle is the iptables table TPROXY rules live in; TPROXY can only
+// redirect packets that haven't been routed yet, which only the mangle
+// table's PREROUTING chain sees.
+const TableMangle = "mangle"
+
+// ChainName is the edgemesh-owned chain TPROXY rules are installed into, so
+// they can be listed/flushed independently of any other mangle rules
+// (including the ones kube-proxy itself may install).
+const ChainName = "EDGEMESH-TPROXY"
+
+// RouteTable is the policy routing table edgemesh points fwmark-ed traffic
+// at. Marking a packet TPROXY is not enough by itself: without a rule
+// sending marked packets to a table that routes them locally, the kernel
+// keeps treating them as in-transit and never hands them to the tproxy
+// listener.
+const RouteTable = 100
+
+// InstallRules creates the EDGEMESH-TPROXY chain, wires it into PREROUTING,
+// and installs the fwmark policy route TPROXY relies on to actually deliver
+// marked packets to the local tproxy listener. Interception is scoped to
+// serviceCIDRs so traffic to anything else - the node's own tunnel, kubelet,
+// SSH, etc. - is left alone instead of being swept into the chain.
+func InstallRules(listenIP string, listenPort, mark int, serviceCIDRs []string) error {
+	if len(serviceCIDRs) == 0 {
+		return fmt.Errorf("no service cidrs given, refusing to intercept all prerouting traffic")
+	}
+
+	if err := installRouting(mark); err != nil {
+		return err
+	}
+
+	if err := run("-t", TableMangle, "-N", ChainName); err != nil {
+		// chain may already exist from a previous run; that's fine.
+		_ = err
+	}
+	if err := run("-t", TableMangle, "-F", ChainName); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", ChainName, err)
+	}
+
+	if err := run("-t", TableMangle, "-A", ChainName,
+		"-d", listenIP, "-j", "RETURN"); err != nil {
+		return fmt.Errorf("failed to install local-traffic bypass rule: %w", err)
+	}
+
+	for _, cidr := range serviceCIDRs {
+		if err := run("-t", TableMangle, "-A", ChainName,
+			"-d", cidr, "-p", "tcp", "-j", "TPROXY",
+			"--tproxy-mark", strconv.Itoa(mark)+"/0xffffffff",
+			"--on-ip", listenIP, "--on-port", strconv.Itoa(listenPort)); err != nil {
+			return fmt.Errorf("failed to install tcp TPROXY rule for %s: %w", cidr, err)
+		}
+		if err := run("-t", TableMangle, "-A", ChainName,
+			"-d", cidr, "-p", "udp", "-j", "TPROXY",
+			"--tproxy-mark", strconv.Itoa(mark)+"/0xffffffff",
+			"--on-ip", listenIP, "--on-port", strconv.Itoa(listenPort)); err != nil {
+			return fmt.Errorf("failed to install udp TPROXY rule for %s: %w", cidr, err)
+		}
+	}
+
+	if err := run("-t", TableMangle, "-C", "PREROUTING", "-j", ChainName); err != nil {
+		if err := run("-t", TableMangle, "-I", "PREROUTING", "-j", ChainName); err != nil {
+			return fmt.Errorf("failed to hook %s into PREROUTING: %w", ChainName, err)
+		}
+	}
+
+	return nil
+}
+
+// installRouting adds the "deliver marked packets locally" policy route
+// TPROXY needs, for both address families: a rule sending fwmark-ed packets
+// to RouteTable, and a route in RouteTable that resolves anything in that
+// table to the loopback device. Both steps are idempotent.
+func installRouting(mark int) error {
+	if err := runIPIdempotent("ip", "rule", "add", "fwmark", strconv.Itoa(mark), "lookup", strconv.Itoa(RouteTable)); err != nil {
+		return fmt.Errorf("failed to add ipv4 fwmark rule: %w", err)
+	}
+	if err := runIPIdempotent("ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", strconv.Itoa(RouteTable)); err != nil {
+		return fmt.Errorf("failed to add ipv4 local route: %w", err)
+	}
+	if err := runIPIdempotent("ip", "-6", "rule", "add", "fwmark", strconv.Itoa(mark), "lookup", strconv.Itoa(RouteTable)); err != nil {
+		return fmt.Errorf("failed to add ipv6 fwmark rule: %w", err)
+	}
+	if err := runIPIdempotent("ip", "-6", "route", "add", "local", "::/0", "dev", "lo", "table", strconv.Itoa(RouteTable)); err != nil {
+		return fmt.Errorf("failed to add ipv6 local route: %w", err)
+	}
+	return nil
+}
+
+// removeRouting tears down what installRouting added. Errors are ignored,
+// same as the rest of RemoveRules: this runs on shutdown/cleanup, where
+// there's nothing left to do about a failure and a rule that was never
+// installed (or already removed) shouldn't block the rest of teardown.
+func removeRouting(mark int) {
+	_ = run2("ip", "rule", "del", "fwmark", strconv.Itoa(mark), "lookup", strconv.Itoa(RouteTable))
+	_ = run2("ip", "route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", strconv.Itoa(RouteTable))
+	_ = run2("ip", "-6", "rule", "del", "fwmark", strconv.Itoa(mark), "lookup", strconv.Itoa(RouteTable))
+	_ = run2("ip", "-6", "route", "del", "local", "::/0", "dev", "lo", "table", strconv.Itoa(RouteTable))
+}
+
+// RemoveRules unhooks and deletes the EDGEMESH-TPROXY chain and the fwmark
+// policy route installed for mark, undoing InstallRules.
+func RemoveRules(mark int) error {
+	removeRouting(mark)
+	_ = run("-t", TableMangle, "-D", "PREROUTING", "-j", ChainName)
+	_ = run("-t", TableMangle, "-F", ChainName)
+	return run("-t", TableMangle, "-X", ChainName)
+}
+
+func run(args ...string) error {
+	return run2("iptables", args...)
+}
+
+func run2(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %v failed: %v, output: %s", name, args, err, out)
+	}
+	return nil
+}
+
+// runIPIdempotent runs an "ip ... add" command, treating the "File exists"
+// failure it returns when the rule/route is already there as success.
+func runIPIdempotent(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil && !bytes.Contains(out, []byte("File exists")) {
+		return fmt.Errorf("%s %v failed: %v, output: %s", name, args, err, out)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package tproxy
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// sockaddrIn mirrors struct sockaddr_in, used to decode SO_ORIGINAL_DST for
+// an IPv4 connection.
+type sockaddrIn struct {
+	family uint16
+	port   uint16
+	addr   [4]byte
+	zero   [8]byte
+}
+
+// sockaddrIn6 mirrors struct sockaddr_in6, used to decode SO_ORIGINAL_DST
+// for an IPv6 connection.
+type sockaddrIn6 struct {
+	family   uint16
+	port     uint16
+	flowinfo uint32
+	addr     [16]byte
+	scopeID  uint32
+}
+
+// soOriginalDst is SO_ORIGINAL_DST from linux/netfilter_ipv4.h, used at the
+// SOL_IP level for IPv4 connections. It is not exposed by the syscall
+// package.
+const soOriginalDst = 80
+
+// ip6tSoOriginalDst is IP6T_SO_ORIGINAL_DST from
+// linux/netfilter_ipv6/ip6_tables.h, the IPv6 counterpart of soOriginalDst,
+// read at the SOL_IPV6 level. It shares the same numeric value as its IPv4
+// counterpart but lives under a different option level.
+const ip6tSoOriginalDst = 80
+
+// solIPV6 is SOL_IPV6, the option level IP6T_SO_ORIGINAL_DST is read at.
+const solIPV6 = 41
+
+// getOriginalDst reads SO_ORIGINAL_DST to recover the destination a
+// TPROXY-intercepted TCP connection was headed for before iptables
+// redirected it to this listener, for both IPv4 (SOL_IP) and IPv6
+// (SOL_IPV6/IP6T_SO_ORIGINAL_DST) connections.
+func getOriginalDst(conn *net.TCPConn) (*net.TCPAddr, error) {
+	file, err := conn.File()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conn fd: %w", err)
+	}
+	defer file.Close()
+	fd := int(file.Fd())
+
+	localAddr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("unexpected local address type %T", conn.LocalAddr())
+	}
+	if localAddr.IP.To4() != nil {
+		return getOriginalDstV4(fd)
+	}
+	return getOriginalDstV6(fd)
+}
+
+func getOriginalDstV4(fd int) (*net.TCPAddr, error) {
+	var addr sockaddrIn
+	size := uint32(unsafe.Sizeof(addr))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), uintptr(syscall.SOL_IP), uintptr(soOriginalDst),
+		uintptr(unsafe.Pointer(&addr)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("getsockopt SO_ORIGINAL_DST: %w", errno)
+	}
+
+	ip := net.IPv4(addr.addr[0], addr.addr[1], addr.addr[2], addr.addr[3])
+	port := int(addr.port>>8) | int(addr.port&0xff)<<8
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func getOriginalDstV6(fd int) (*net.TCPAddr, error) {
+	var addr sockaddrIn6
+	size := uint32(unsafe.Sizeof(addr))
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), uintptr(solIPV6), uintptr(ip6tSoOriginalDst),
+		uintptr(unsafe.Pointer(&addr)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("getsockopt IP6T_SO_ORIGINAL_DST: %w", errno)
+	}
+
+	ip := make(net.IP, 16)
+	copy(ip, addr.addr[:])
+	port := int(addr.port>>8) | int(addr.port&0xff)<<8
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
@@ -0,0 +1,101 @@
+package tproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubeedge/edgemesh/agent/pkg/tunnel"
+	"github.com/kubeedge/edgemesh/agent/pkg/tunnel/proxy"
+	"github.com/kubeedge/edgemesh/common/informers"
+	"github.com/kubeedge/edgemesh/common/util"
+)
+
+// NewTProxy creates a TProxy bound to ip:port with IP_TRANSPARENT set, so the
+// listener can accept connections whose destination isn't its own address.
+// stopCh shuts down the Service/Endpoints informer cache resolveService
+// reads from.
+func NewTProxy(ip net.IP, port int, ifm *informers.Manager, stopCh <-chan struct{}) (*TProxy, error) {
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	listener, err := lc.Listen(context.Background(), "tcp", fmt.Sprintf("%s:%d", ip, port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen tproxy tcp %s:%d: %w", ip, port, err)
+	}
+
+	t := &TProxy{ListenIP: ip, ListenPort: port, ifm: ifm, listener: listener}
+	if err := t.startInformers(stopCh); err != nil {
+		return nil, fmt.Errorf("failed to start tproxy informers: %w", err)
+	}
+	return t, nil
+}
+
+// Start accepts intercepted TCP connections and the UDP relay, proxying each
+// one through tunnel.Agent.ProxySvc.
+func (t *TProxy) Start() error {
+	go func() {
+		for {
+			conn, err := t.listener.Accept()
+			if err != nil {
+				klog.Warningf("tproxy: accept error: %v", err)
+				continue
+			}
+			go t.handleTCP(conn)
+		}
+	}()
+
+	if err := t.startUDP(); err != nil {
+		return fmt.Errorf("failed to start tproxy udp listener: %w", err)
+	}
+	return nil
+}
+
+func (t *TProxy) handleTCP(conn net.Conn) {
+	defer conn.Close()
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		klog.Errorf("tproxy: unexpected connection type %T", conn)
+		return
+	}
+
+	origAddr, err := getOriginalDst(tcpConn)
+	if err != nil {
+		klog.Errorf("tproxy: failed to get original destination: %v", err)
+		return
+	}
+
+	svc, err := t.resolveService(origAddr.IP, origAddr.Port, v1.ProtocolTCP)
+	if err != nil {
+		klog.Errorf("tproxy: failed to resolve %s: %v", origAddr, err)
+		return
+	}
+
+	stream, err := tunnel.Agent.ProxySvc.GetProxyStream(proxy.ProxyOptions{
+		Protocol: "tcp",
+		NodeName: svc.NodeName,
+		IP:       svc.IP,
+		Port:     svc.Port,
+	})
+	if err != nil {
+		klog.Errorf("tproxy: get proxy stream to %s error: %v", svc.NodeName, err)
+		return
+	}
+
+	klog.Infof("tproxy: intercepted %s -> %s, proxying via %s", conn.RemoteAddr(), origAddr, svc.NodeName)
+	util.ProxyConn(stream, conn)
+}
@@ -0,0 +1,146 @@
+// Package tproxy implements a transparent interception mode for the edge L4
+// proxy. Instead of requiring applications to dial the SOCKS5 listener
+// explicitly, it relies on Linux TPROXY to hand already-routed TCP/UDP
+// traffic straight to edgemesh-agent, which resolves the original
+// destination against the informer cache and forwards it through the same
+// tunnel.Agent.ProxySvc data path the SOCKS5 listener uses.
+package tproxy
+
+import (
+	"fmt"
+	"net"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sinformers "k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/kubeedge/edgemesh/common/informers"
+)
+
+// TProxy transparently intercepts connections that iptables has
+// TPROXY-marked towards ListenIP:ListenPort.
+type TProxy struct {
+	ListenIP   net.IP
+	ListenPort int
+
+	ifm      *informers.Manager
+	listener net.Listener
+
+	serviceLister   corelisters.ServiceLister
+	endpointsLister corelisters.EndpointsLister
+}
+
+// resolvedService is the informer-cache lookup result for an intercepted
+// destination address.
+type resolvedService struct {
+	NodeName string
+	IP       string
+	Port     int32
+}
+
+// startInformers wires up the Service/Endpoints listers resolveService reads
+// from, so lookups hit a local cache instead of making a live API call per
+// intercepted connection. The cache comes from an informer factory of its
+// own, built from ifm's kube client; it is not shared with ifm's informers,
+// so Services are watched a second time.
+func (t *TProxy) startInformers(stopCh <-chan struct{}) error {
+	factory := k8sinformers.NewSharedInformerFactory(t.ifm.GetKubeClient(), 0)
+	svcInformer := factory.Core().V1().Services()
+	epInformer := factory.Core().V1().Endpoints()
+	t.serviceLister = svcInformer.Lister()
+	t.endpointsLister = epInformer.Lister()
+
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, svcInformer.Informer().HasSynced, epInformer.Informer().HasSynced) {
+		return fmt.Errorf("failed to sync service/endpoints informer cache")
+	}
+	return nil
+}
+
+// resolveService looks up which Service owns ip:port for protocol using the
+// shared informer cache, then resolves that Service to the node backing one
+// of its ready endpoints, since GetProxyStream tunnels to a node rather than
+// a Service.
+func (t *TProxy) resolveService(ip net.IP, port int, protocol v1.Protocol) (*resolvedService, error) {
+	if ip == nil {
+		return nil, fmt.Errorf("invalid original destination address")
+	}
+
+	svc, svcPort, err := t.findService(ip, port, protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeName, err := t.findNode(svc, svcPort)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resolvedService{NodeName: nodeName, IP: ip.String(), Port: int32(port)}, nil
+}
+
+// findService matches ip against a Service's ClusterIP/ClusterIPs (so
+// dual-stack and IPv6-only Services resolve, not just the legacy
+// single-stack ClusterIP field) and port against one of its declared ports.
+func (t *TProxy) findService(ip net.IP, port int, protocol v1.Protocol) (*v1.Service, v1.ServicePort, error) {
+	svcs, err := t.serviceLister.List(labels.Everything())
+	if err != nil {
+		return nil, v1.ServicePort{}, err
+	}
+	for _, svc := range svcs {
+		if !hasClusterIP(svc, ip.String()) {
+			continue
+		}
+		for _, svcPort := range svc.Spec.Ports {
+			if svcPort.Protocol == protocol && int(svcPort.Port) == port {
+				return svc, svcPort, nil
+			}
+		}
+	}
+	return nil, v1.ServicePort{}, fmt.Errorf("no service found for %s:%d/%s", ip, port, protocol)
+}
+
+// hasClusterIP reports whether svc claims ip as one of its cluster IPs,
+// checking both the legacy single-stack field and the dual-stack ClusterIPs
+// list so either family resolves.
+func hasClusterIP(svc *v1.Service, ip string) bool {
+	if svc.Spec.ClusterIP == ip {
+		return true
+	}
+	for _, clusterIP := range svc.Spec.ClusterIPs {
+		if clusterIP == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// findNode resolves svc to the node backing one of its ready endpoints for
+// svcPort, matching the way edgemesh tunnels to a node's edgemesh-agent
+// rather than directly to a pod.
+func (t *TProxy) findNode(svc *v1.Service, svcPort v1.ServicePort) (string, error) {
+	ep, err := t.endpointsLister.Endpoints(svc.Namespace).Get(svc.Name)
+	if err != nil {
+		return "", fmt.Errorf("no endpoints found for service %s/%s: %w", svc.Namespace, svc.Name, err)
+	}
+	for _, subset := range ep.Subsets {
+		portMatches := false
+		for _, p := range subset.Ports {
+			if p.Name == svcPort.Name {
+				portMatches = true
+				break
+			}
+		}
+		if !portMatches {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			if addr.NodeName != nil && *addr.NodeName != "" {
+				return *addr.NodeName, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no node found backing service %s/%s", svc.Namespace, svc.Name)
+}
@@ -0,0 +1,267 @@
+package tproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/kubeedge/edgemesh/agent/pkg/tunnel"
+	"github.com/kubeedge/edgemesh/agent/pkg/tunnel/proxy"
+)
+
+// startUDP opens a UDP socket with IP_TRANSPARENT and IP_RECVORIGDSTADDR (or
+// their IPv6 counterparts, when ListenIP is an IPv6 address) set, so it can
+// both accept datagrams addressed to arbitrary destinations and recover
+// those destinations from each datagram's ancillary data.
+func (t *TProxy) startUDP() error {
+	if t.ListenIP.To4() != nil {
+		return t.startUDPv4()
+	}
+	return t.startUDPv6()
+}
+
+func (t *TProxy) startUDPv4() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create udp socket: %w", err)
+	}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1); err != nil {
+		return fmt.Errorf("failed to set IP_TRANSPARENT: %w", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1); err != nil {
+		return fmt.Errorf("failed to set IP_RECVORIGDSTADDR: %w", err)
+	}
+
+	addr := unix.SockaddrInet4{Port: t.ListenPort}
+	copy(addr.Addr[:], t.ListenIP.To4())
+	if err := unix.Bind(fd, &addr); err != nil {
+		return fmt.Errorf("failed to bind udp socket: %w", err)
+	}
+
+	go t.serveUDP(fd)
+	return nil
+}
+
+func (t *TProxy) startUDPv6() error {
+	fd, err := unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create udp6 socket: %w", err)
+	}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1); err != nil {
+		return fmt.Errorf("failed to set IPV6_TRANSPARENT: %w", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1); err != nil {
+		return fmt.Errorf("failed to set IPV6_RECVORIGDSTADDR: %w", err)
+	}
+
+	addr := unix.SockaddrInet6{Port: t.ListenPort}
+	copy(addr.Addr[:], t.ListenIP.To16())
+	if err := unix.Bind(fd, &addr); err != nil {
+		return fmt.Errorf("failed to bind udp6 socket: %w", err)
+	}
+
+	go t.serveUDP(fd)
+	return nil
+}
+
+// udpSession is a client<->destination pairing kept alive across datagrams:
+// a tunnel stream carrying payloads to the resolved destination, and a
+// transparent UDP socket, bound to the original destination and connected to
+// the client, used to relay whatever the stream sends back so the client
+// sees a reply that looks like it came from the service it actually dialed.
+type udpSession struct {
+	stream io.ReadWriteCloser
+	reply  *net.UDPConn
+}
+
+// serveUDP reads intercepted datagrams, recovers their original destination
+// from the IP_RECVORIGDSTADDR/IPV6_RECVORIGDSTADDR ancillary data, and
+// relays the payload through a tunnel stream kept open for the lifetime of
+// the client/destination pairing, so replies (DNS answers, QUIC handshake
+// packets, ...) have a stream to read from instead of the one-shot stream
+// being closed before anything could come back.
+func (t *TProxy) serveUDP(fd int) {
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, 1024)
+
+	var mu sync.Mutex
+	sessions := make(map[string]*udpSession)
+
+	for {
+		n, oobn, _, from, err := unix.Recvmsg(fd, buf, oob, 0)
+		if err != nil {
+			klog.Errorf("tproxy: udp recvmsg error: %v", err)
+			return
+		}
+
+		origAddr, err := unix.ParseOrigDstAddr(oob[:oobn])
+		if err != nil {
+			klog.Errorf("tproxy: failed to parse udp original destination: %v", err)
+			continue
+		}
+		host, port, err := udpOrigDstAddrPort(origAddr)
+		if err != nil {
+			klog.Errorf("tproxy: %v", err)
+			continue
+		}
+
+		client, err := sockaddrToUDPAddr(from)
+		if err != nil {
+			klog.Errorf("tproxy: %v", err)
+			continue
+		}
+
+		key := client.String() + "->" + net.JoinHostPort(host, strconv.Itoa(port))
+
+		mu.Lock()
+		sess, ok := sessions[key]
+		mu.Unlock()
+		if !ok {
+			sess, err = t.newUDPSession(host, port, client)
+			if err != nil {
+				klog.Errorf("tproxy: failed to start udp session %s: %v", key, err)
+				continue
+			}
+			mu.Lock()
+			sessions[key] = sess
+			mu.Unlock()
+			go relayUDPSessionReplies(key, sess, sessions, &mu)
+		}
+
+		if _, err := sess.stream.Write(buf[:n]); err != nil {
+			klog.Errorf("tproxy: udp write to stream error: %v", err)
+			mu.Lock()
+			delete(sessions, key)
+			mu.Unlock()
+			sess.stream.Close()
+			sess.reply.Close()
+		}
+	}
+}
+
+// newUDPSession resolves host:port against the informer cache and opens the
+// pair of sockets a udpSession relays between: the tunnel stream to the
+// resolved node, and a transparent socket that replies will be spoofed from.
+func (t *TProxy) newUDPSession(host string, port int, client *net.UDPAddr) (*udpSession, error) {
+	svc, err := t.resolveService(net.ParseIP(host), port, v1.ProtocolUDP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve udp destination %s:%d: %w", host, port, err)
+	}
+
+	stream, err := tunnel.Agent.ProxySvc.GetProxyStream(proxy.ProxyOptions{
+		Protocol: "udp",
+		NodeName: svc.NodeName,
+		IP:       svc.IP,
+		Port:     svc.Port,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("udp get proxy stream to %s error: %w", svc.NodeName, err)
+	}
+
+	reply, err := dialTransparentUDP(&net.UDPAddr{IP: net.ParseIP(host), Port: port}, client)
+	if err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("failed to open reply socket to %s as %s:%d: %w", client, host, port, err)
+	}
+
+	return &udpSession{stream: stream, reply: reply}, nil
+}
+
+// relayUDPSessionReplies reads whatever the tunnel stream sends back and
+// writes it to the client through sess.reply, until either side closes.
+func relayUDPSessionReplies(key string, sess *udpSession, sessions map[string]*udpSession, mu *sync.Mutex) {
+	defer sess.stream.Close()
+	defer sess.reply.Close()
+	defer func() {
+		mu.Lock()
+		delete(sessions, key)
+		mu.Unlock()
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := sess.stream.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := sess.reply.Write(buf[:n]); err != nil {
+			klog.Errorf("tproxy: udp reply write error: %v", err)
+			return
+		}
+	}
+}
+
+// dialTransparentUDP opens a UDP socket with IP_TRANSPARENT (or
+// IPV6_TRANSPARENT, when dst is IPv6) set, bound to src and connected to
+// dst, so writes through it carry src as their source address even though
+// src isn't an address actually configured on this host. SO_REUSEADDR and
+// SO_REUSEPORT let multiple sessions relaying from the same service share
+// src: each socket still ends up with a distinct (src, dst) pair once
+// connected to its own client.
+func dialTransparentUDP(src, dst *net.UDPAddr) (*net.UDPConn, error) {
+	isV6 := dst.IP.To4() == nil
+	dialer := net.Dialer{
+		LocalAddr: src,
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				if sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); sockErr != nil {
+					return
+				}
+				if sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); sockErr != nil {
+					return
+				}
+				if isV6 {
+					sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+				} else {
+					sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+				}
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	conn, err := dialer.Dial("udp", dst.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// udpOrigDstAddrPort extracts the host and port from either address family
+// unix.ParseOrigDstAddr can return.
+func udpOrigDstAddrPort(origAddr unix.Sockaddr) (string, int, error) {
+	switch dst := origAddr.(type) {
+	case *unix.SockaddrInet4:
+		return net.IP(dst.Addr[:]).String(), dst.Port, nil
+	case *unix.SockaddrInet6:
+		return net.IP(dst.Addr[:]).String(), dst.Port, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported udp original destination address family %T", origAddr)
+	}
+}
+
+// sockaddrToUDPAddr converts the client address unix.Recvmsg returns into a
+// net.UDPAddr, for keying sessions and as the connect target of the
+// transparent reply socket.
+func sockaddrToUDPAddr(sa unix.Sockaddr) (*net.UDPAddr, error) {
+	switch addr := sa.(type) {
+	case *unix.SockaddrInet4:
+		return &net.UDPAddr{IP: net.IP(addr.Addr[:]), Port: addr.Port}, nil
+	case *unix.SockaddrInet6:
+		return &net.UDPAddr{IP: net.IP(addr.Addr[:]), Port: addr.Port}, nil
+	default:
+		return nil, fmt.Errorf("unsupported udp client address family %T", sa)
+	}
+}
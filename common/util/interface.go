@@ -0,0 +1,93 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// ChooseHostInterface finds the network interface edgemesh-agent should
+// listen on when ListenInterface isn't configured explicitly. It prefers
+// whichever interface owns the default route, falling back to the first
+// usable interface with a global-scope address. Ported from Kubernetes'
+// utilnet.ChooseHostInterface, trimmed to what edgemesh needs.
+func ChooseHostInterface() (string, error) {
+	if name, err := interfaceWithDefaultRoute("/proc/net/route", parseIPv4DefaultRoute); err == nil {
+		return name, nil
+	}
+	if name, err := interfaceWithDefaultRoute("/proc/net/ipv6_route", parseIPv6DefaultRoute); err == nil {
+		return name, nil
+	}
+	return firstUsableInterface()
+}
+
+// interfaceWithDefaultRoute scans routeFile line by line and returns the
+// interface name isDefault recognizes as owning the default route.
+func interfaceWithDefaultRoute(routeFile string, isDefault func([]string) (string, bool)) (string, error) {
+	file, err := os.Open(routeFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // skip the header line
+	for scanner.Scan() {
+		if name, ok := isDefault(strings.Fields(scanner.Text())); ok {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no default route found in %s", routeFile)
+}
+
+// parseIPv4DefaultRoute recognizes a default route in /proc/net/route:
+// "Iface Destination Gateway Flags ...", where Destination is 00000000.
+func parseIPv4DefaultRoute(fields []string) (string, bool) {
+	if len(fields) < 2 {
+		return "", false
+	}
+	if fields[1] == "00000000" {
+		return fields[0], true
+	}
+	return "", false
+}
+
+// parseIPv6DefaultRoute recognizes a default route in /proc/net/ipv6_route:
+// "Destination DestinationPrefixLen ... Iface", where Destination and its
+// prefix length are both zero.
+func parseIPv6DefaultRoute(fields []string) (string, bool) {
+	if len(fields) < 10 {
+		return "", false
+	}
+	if fields[0] == strings.Repeat("0", 32) && fields[1] == "00" {
+		return fields[9], true
+	}
+	return "", false
+}
+
+// firstUsableInterface falls back to the first up, non-loopback,
+// non-point-to-point interface with at least one global-scope address.
+func firstUsableInterface() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagPointToPoint != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no usable network interface found")
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"fmt"
+	"net"
+)
+
+// GetInterfaceIPs returns every global-scope IP address (IPv4 and IPv6)
+// assigned to the named network interface. Dual-stack and IPv6-only
+// clusters need every family an interface owns, not just the first IPv4
+// address found.
+func GetInterfaceIPs(ifaceName string) ([]net.IP, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", ifaceName, err)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses on %s: %w", ifaceName, err)
+	}
+
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		ips = append(ips, ipNet.IP)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no usable address found on interface %s", ifaceName)
+	}
+	return ips, nil
+}